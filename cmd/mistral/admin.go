@@ -0,0 +1,99 @@
+/*-
+ * Copyright © 2017, Jörg Pernfuß <code.jpe@gmail.com>
+ * All rights reserved.
+ *
+ * Use of this source code is governed by a 2-clause BSD license
+ * that can be found in the LICENSE file.
+ */
+
+package main // import "github.com/mjolnir42/mistral/cmd/mistral"
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/pprof"
+	"sort"
+	"strings"
+
+	"github.com/mjolnir42/mistral/internal/mistral"
+	metrics "github.com/rcrowley/go-metrics"
+)
+
+// newAdminMux assembles the http.ServeMux used by the monitoring
+// listener. It exposes the Prometheus metrics endpoint, the health
+// check and the pprof debug handlers so the data-plane listener can
+// stay minimal.
+func newAdminMux(registry *metrics.Registry) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc(`/metrics`, prometheusHandler(registry))
+	mux.HandleFunc(`/health`, func(w http.ResponseWriter, r *http.Request) {
+		mistral.Health(w, r, nil)
+	})
+	mux.HandleFunc(`/debug/pprof/`, pprof.Index)
+	mux.HandleFunc(`/debug/pprof/cmdline`, pprof.Cmdline)
+	mux.HandleFunc(`/debug/pprof/profile`, pprof.Profile)
+	mux.HandleFunc(`/debug/pprof/symbol`, pprof.Symbol)
+	mux.HandleFunc(`/debug/pprof/trace`, pprof.Trace)
+	return mux
+}
+
+// prometheusHandler walks registry and renders every registered
+// counter, meter, gauge, histogram and timer in Prometheus text
+// exposition format.
+func prometheusHandler(registry *metrics.Registry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(`Content-Type`, `text/plain; version=0.0.4`)
+
+		names := []string{}
+		snapshot := map[string]interface{}{}
+		(*registry).Each(func(name string, i interface{}) {
+			names = append(names, name)
+			snapshot[name] = i
+		})
+		sort.Strings(names)
+
+		for _, name := range names {
+			writePrometheusMetric(w, prometheusName(name), snapshot[name])
+		}
+	}
+}
+
+// prometheusName rewrites a go-metrics registry key such as
+// `/mistral/requests` into a Prometheus-legal metric name.
+func prometheusName(name string) string {
+	r := strings.NewReplacer(`/`, `_`, `-`, `_`, `.`, `_`)
+	return `mistral` + r.Replace(name)
+}
+
+// writePrometheusMetric renders a single go-metrics value as one or
+// more Prometheus sample lines, prefixed by the appropriate TYPE
+// comment.
+func writePrometheusMetric(w http.ResponseWriter, name string, i interface{}) {
+	switch m := i.(type) {
+	case metrics.Counter:
+		fmt.Fprintf(w, "# TYPE %s counter\n%s %d\n", name, name, m.Count())
+	case metrics.Meter:
+		fmt.Fprintf(w, "# TYPE %s counter\n%s %d\n", name, name, m.Count())
+	case metrics.Gauge:
+		fmt.Fprintf(w, "# TYPE %s gauge\n%s %d\n", name, name, m.Value())
+	case metrics.GaugeFloat64:
+		fmt.Fprintf(w, "# TYPE %s gauge\n%s %g\n", name, name, m.Value())
+	case metrics.Histogram:
+		writePercentiles(w, name, m.Percentiles([]float64{0.5, 0.9, 0.99}))
+		fmt.Fprintf(w, "%s_sum %d\n%s_count %d\n", name, m.Sum(), name, m.Count())
+	case metrics.Timer:
+		writePercentiles(w, name, m.Percentiles([]float64{0.5, 0.9, 0.99}))
+		fmt.Fprintf(w, "%s_sum %d\n%s_count %d\n", name, m.Sum(), name, m.Count())
+	}
+}
+
+// writePercentiles renders the p50/p90/p99 summary lines shared by
+// histograms and timers.
+func writePercentiles(w http.ResponseWriter, name string, ps []float64) {
+	fmt.Fprintf(w, "# TYPE %s summary\n", name)
+	fmt.Fprintf(w, "%s{quantile=\"0.5\"} %g\n", name, ps[0])
+	fmt.Fprintf(w, "%s{quantile=\"0.9\"} %g\n", name, ps[1])
+	fmt.Fprintf(w, "%s{quantile=\"0.99\"} %g\n", name, ps[2])
+}
+
+// vim: ts=4 sw=4 sts=4 noet fenc=utf-8 ffs=unix
@@ -0,0 +1,77 @@
+/*-
+ * Copyright © 2017, Jörg Pernfuß <code.jpe@gmail.com>
+ * All rights reserved.
+ *
+ * Use of this source code is governed by a 2-clause BSD license
+ * that can be found in the LICENSE file.
+ */
+
+package main // import "github.com/mjolnir42/mistral/cmd/mistral"
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// idleTracker counts the connections a http.Server currently has
+// open via ConnState, so shutdown can wait for real drain instead
+// of sleeping a fixed duration. It tracks membership per
+// net.Conn rather than a bare counter, since a single keep-alive
+// connection cycles through StateActive/StateIdle once per request
+// and a counter incremented/decremented on the wrong transitions
+// drifts instead of returning to zero.
+type idleTracker struct {
+	mu    sync.Mutex
+	conns map[net.Conn]struct{}
+}
+
+// ConnState is installed as http.Server.ConnState. A connection is
+// counted only while it is actively serving a request: it is added
+// on StateActive and removed on StateIdle, StateClosed or
+// StateHijacked. StateNew is intentionally not counted, since a
+// freshly accepted connection isn't serving anything yet.
+func (t *idleTracker) ConnState(conn net.Conn, state http.ConnState) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.conns == nil {
+		t.conns = make(map[net.Conn]struct{})
+	}
+
+	switch state {
+	case http.StateActive:
+		t.conns[conn] = struct{}{}
+	case http.StateIdle, http.StateClosed, http.StateHijacked:
+		delete(t.conns, conn)
+	}
+}
+
+// ActiveConnections returns the current count of connections the
+// tracked server has actively serving a request.
+func (t *idleTracker) ActiveConnections() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.conns)
+}
+
+// waitForIdle blocks until the tracker reports no active
+// connections or timeout elapses, whichever comes first.
+func waitForIdle(t *idleTracker, timeout time.Duration) {
+	deadline := time.After(timeout)
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		if t.ActiveConnections() == 0 {
+			return
+		}
+		select {
+		case <-deadline:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// vim: ts=4 sw=4 sts=4 noet fenc=utf-8 ffs=unix
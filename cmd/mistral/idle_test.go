@@ -0,0 +1,73 @@
+/*-
+ * Copyright © 2017, Jörg Pernfuß <code.jpe@gmail.com>
+ * All rights reserved.
+ *
+ * Use of this source code is governed by a 2-clause BSD license
+ * that can be found in the LICENSE file.
+ */
+
+package main // import "github.com/mjolnir42/mistral/cmd/mistral"
+
+import (
+	"net"
+	"net/http"
+	"testing"
+)
+
+// TestIdleTrackerKeepAlive exercises the ConnState transitions a
+// real keep-alive connection serving two requests goes through:
+// new, active, idle, active, idle, closed. The tracker must end up
+// back at zero active connections, not drift upward.
+func TestIdleTrackerKeepAlive(t *testing.T) {
+	conn, peer := net.Pipe()
+	defer peer.Close()
+
+	tracker := &idleTracker{}
+	transitions := []http.ConnState{
+		http.StateNew,
+		http.StateActive,
+		http.StateIdle,
+		http.StateActive,
+		http.StateIdle,
+		http.StateClosed,
+	}
+
+	for _, state := range transitions {
+		tracker.ConnState(conn, state)
+	}
+
+	if got := tracker.ActiveConnections(); got != 0 {
+		t.Fatalf("ActiveConnections() = %d, want 0 after keep-alive connection closed", got)
+	}
+}
+
+// TestIdleTrackerConcurrentConnections verifies one connection's
+// transitions don't affect another's count.
+func TestIdleTrackerConcurrentConnections(t *testing.T) {
+	connA, peerA := net.Pipe()
+	defer peerA.Close()
+	connB, peerB := net.Pipe()
+	defer peerB.Close()
+
+	tracker := &idleTracker{}
+	tracker.ConnState(connA, http.StateNew)
+	tracker.ConnState(connA, http.StateActive)
+	tracker.ConnState(connB, http.StateNew)
+	tracker.ConnState(connB, http.StateActive)
+
+	if got := tracker.ActiveConnections(); got != 2 {
+		t.Fatalf("ActiveConnections() = %d, want 2 with both connections active", got)
+	}
+
+	tracker.ConnState(connA, http.StateIdle)
+	if got := tracker.ActiveConnections(); got != 1 {
+		t.Fatalf("ActiveConnections() = %d, want 1 after connA goes idle", got)
+	}
+
+	tracker.ConnState(connB, http.StateClosed)
+	if got := tracker.ActiveConnections(); got != 0 {
+		t.Fatalf("ActiveConnections() = %d, want 0 after connB closes", got)
+	}
+}
+
+// vim: ts=4 sw=4 sts=4 noet fenc=utf-8 ffs=unix
@@ -12,8 +12,8 @@ import (
 	"context"
 	"flag"
 	"fmt"
+	"net"
 	"net/http"
-	"net/url"
 	"os"
 	"os/signal"
 	"path/filepath"
@@ -28,6 +28,7 @@ import (
 	"github.com/mjolnir42/legacy"
 	"github.com/mjolnir42/mistral/internal/mistral"
 	metrics "github.com/rcrowley/go-metrics"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 )
 
 var githash, shorthash, builddate, buildtime string
@@ -91,6 +92,34 @@ func main() {
 	// this channel is used by the handlers on error
 	handlerDeath := make(chan error)
 
+	// assemble listen address and open the listener before the
+	// metrics producer socket and the Mistral handlers are started.
+	// For a unix socket this briefly narrows the process-wide umask,
+	// and syscall.Umask is not per-goroutine, so those goroutines
+	// started below must not be racing against that window. The
+	// logfile rotation goroutine started above is already running
+	// and is not held off during it. ListenNetwork is `tcp` (the
+	// default) or `unix`, in which case ListenAddress is a
+	// filesystem path rather than a host.
+	listenNetwork := miConf.Mistral.ListenNetwork
+	if listenNetwork == `` {
+		listenNetwork = `tcp`
+	}
+	var listenAddr string
+	switch listenNetwork {
+	case `unix`:
+		listenAddr = miConf.Mistral.ListenAddress
+	default:
+		listenAddr = fmt.Sprintf("%s:%s",
+			miConf.Mistral.ListenAddress,
+			miConf.Mistral.ListenPort,
+		)
+	}
+	ln, err := listen(listenNetwork, listenAddr, miConf.Mistral.ListenUmask)
+	if err != nil {
+		logrus.Fatalf("Could not create listener: %s", err.Error())
+	}
+
 	// setup metrics
 	var metricPrefix string
 	switch miConf.Misc.InstanceName {
@@ -115,7 +144,7 @@ func main() {
 	for i := 0; i < runtime.NumCPU(); i++ {
 		h := mistral.Mistral{
 			Num: i,
-			Input: make(chan *erebos.Transport,
+			Input: make(chan *mistral.Work,
 				miConf.Mistral.HandlerQueueLength),
 			Shutdown: make(chan struct{}),
 			Death:    handlerDeath,
@@ -127,30 +156,87 @@ func main() {
 		logrus.Infof("Launched Mistral handler #%d", i)
 	}
 
-	// assemble listen address
-	listenURL := &url.URL{}
-	listenURL.Scheme = `http`
-	listenURL.Host = fmt.Sprintf("%s:%s",
-		miConf.Mistral.ListenAddress,
-		miConf.Mistral.ListenPort,
+	// set up tracing; tracerShutdown is a no-op when
+	// Mistral.Tracing.Endpoint is unset
+	tracerShutdown, err := initTracer(
+		miConf.Mistral.Tracing.Endpoint,
+		miConf.Mistral.Tracing.ServiceName,
+		miConf.Mistral.Tracing.SampleRatio,
 	)
+	if err != nil {
+		logrus.Fatalf("Could not set up tracing: %s", err.Error())
+	}
 
-	// setup http routes
+	// setup http routes. otelhttp opens the root span per request;
+	// mistral.Endpoint and mistral.Mistral.process carry it through
+	// to the decode/produce child spans, see initTracer's doc
+	// comment for how.
 	router := httprouter.New()
 	router.POST(miConf.Mistral.EndpointPath, mistral.Endpoint)
-	router.GET(`/health`, mistral.Health)
+	tracedRouter := otelhttp.NewHandler(router, `mistral`)
 
-	// start HTTPserver
+	// start HTTPserver, optionally terminating TLS
+	tracker := &idleTracker{}
 	srv := &http.Server{
-		Addr:    listenURL.Host,
-		Handler: router,
+		Handler:   withMiddleware(tracedRouter),
+		ConnState: tracker.ConnState,
 	}
+	useTLS := miConf.Mistral.TLSCertFile != `` && miConf.Mistral.TLSKeyFile != ``
+	if useTLS {
+		tlsConfig, err := newServerTLSConfig(
+			miConf.Mistral.TLSCertFile,
+			miConf.Mistral.TLSKeyFile,
+			miConf.Mistral.TLSClientCAFile,
+			miConf.Mistral.TLSClientAuth,
+		)
+		if err != nil {
+			logrus.Fatalf("Could not set up TLS: %s", err.Error())
+		}
+		srv.TLSConfig = tlsConfig
+	}
+
 	go func() {
-		if err := srv.ListenAndServe(); err != nil {
+		var err error
+		if useTLS {
+			// certificate and key are already loaded into
+			// srv.TLSConfig via GetCertificate
+			err = srv.ServeTLS(ln, ``, ``)
+		} else {
+			err = srv.Serve(ln)
+		}
+		if err != nil && err != http.ErrServerClosed {
 			handlerDeath <- err
 		}
 	}()
 
+	// assemble monitoring listen address. /health, /metrics and
+	// pprof are served from here instead of the data-plane
+	// listener so operators can firewall them off separately. The
+	// admin listener is optional: deployments that upgrade without
+	// adding Mistral.MonitoringAddress to their config keep running
+	// with just the data-plane listener rather than failing to
+	// start outright. A value that is set but unparseable is still
+	// a hard failure, since that is an operator typo, not a missing
+	// opt-in.
+	var adminSrv *http.Server
+	if miConf.Mistral.MonitoringAddress == `` {
+		logrus.Warnln(`Mistral.MonitoringAddress not configured, admin listener (metrics/health/pprof) disabled`)
+	} else {
+		if _, _, err := net.SplitHostPort(miConf.Mistral.MonitoringAddress); err != nil {
+			logrus.Fatalf("Invalid Mistral.MonitoringAddress %q: %s",
+				miConf.Mistral.MonitoringAddress, err.Error())
+		}
+		adminSrv = &http.Server{
+			Addr:    miConf.Mistral.MonitoringAddress,
+			Handler: newAdminMux(&pfxRegistry),
+		}
+		go func() {
+			if err := adminSrv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				handlerDeath <- err
+			}
+		}()
+	}
+
 	// the main loop
 	fault := false
 	shutdown := false
@@ -178,9 +264,25 @@ runloop:
 	}
 
 	if shutdown {
-		// give the loadbalancer time to pick up the failing health
-		// check and remove this instance from service
-		<-time.After(time.Second * 95)
+		// health already flipped to failing above via
+		// mistral.SetShutdown(); wait for in-flight connections to
+		// drain instead of sleeping a fixed duration, but accept a
+		// second shutdown signal as a request for immediate exit
+		drainTimeout := time.Duration(miConf.Mistral.ShutdownTimeout) * time.Second
+		if drainTimeout <= 0 {
+			drainTimeout = 95 * time.Second
+		}
+		drained := make(chan struct{})
+		go func() {
+			waitForIdle(tracker, drainTimeout)
+			close(drained)
+		}()
+		select {
+		case <-drained:
+		case <-c:
+			logrus.Warnln(`Received second shutdown signal, forcing immediate exit`)
+			os.Exit(1)
+		}
 	}
 
 	// close all handlers
@@ -207,13 +309,22 @@ drainloop:
 	// a chance to exit
 	<-time.After(time.Millisecond * 10)
 
-	// stop http server
+	// stop http servers
 	ctx, cancel := context.WithTimeout(
 		context.Background(), 5*time.Second)
 	defer cancel()
 	if err := srv.Shutdown(ctx); err != nil {
 		logrus.Warnf("HTTP shutdown error: %s", err.Error())
 	}
+	if adminSrv != nil {
+		if err := adminSrv.Shutdown(ctx); err != nil {
+			logrus.Warnf("Admin HTTP shutdown error: %s", err.Error())
+		}
+	}
+	removeSocket(listenNetwork, listenAddr)
+	if err := tracerShutdown(ctx); err != nil {
+		logrus.Warnf("Tracer shutdown error: %s", err.Error())
+	}
 	logrus.Infoln(`MISTRAL shutdown complete`)
 	if fault {
 		os.Exit(1)
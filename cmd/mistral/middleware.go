@@ -0,0 +1,118 @@
+/*-
+ * Copyright © 2017, Jörg Pernfuß <code.jpe@gmail.com>
+ * All rights reserved.
+ *
+ * Use of this source code is governed by a 2-clause BSD license
+ * that can be found in the LICENSE file.
+ */
+
+package main // import "github.com/mjolnir42/mistral/cmd/mistral"
+
+import (
+	"net/http"
+	"runtime"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/mjolnir42/mistral/internal/mistral"
+	uuid "github.com/satori/go.uuid"
+)
+
+// requestIDHeader is the header mistral echoes the reference id on,
+// matching the X-Request-Id/X-Reference-Id convention of reading
+// whichever the caller already sent.
+const requestIDHeader = `X-Reference-Id`
+
+// withRequestID reads X-Request-Id or X-Reference-Id from the
+// incoming request, generating a UUID if neither is set, stores it
+// on the request context via mistral.WithRequestID and echoes it
+// back on the response. Storing it through the mistral package
+// rather than a locally scoped key lets mistral.Endpoint read the
+// same id back out with mistral.RequestIDFromContext.
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(`X-Request-Id`)
+		if id == `` {
+			id = r.Header.Get(requestIDHeader)
+		}
+		if id == `` {
+			id = uuid.NewV4().String()
+		}
+		w.Header().Set(requestIDHeader, id)
+
+		ctx := mistral.WithRequestID(r.Context(), id)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// statusRecorder wraps http.ResponseWriter so withAccessLog can
+// observe the status code and byte count a handler wrote.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	s.status = status
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	n, err := s.ResponseWriter.Write(b)
+	s.bytes += n
+	return n, err
+}
+
+// withAccessLog emits one Apache-style access log line per request
+// via logrus, including the reference id assigned by withRequestID.
+func withAccessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+
+		next.ServeHTTP(rec, r)
+
+		logrus.WithFields(logrus.Fields{
+			`method`:      r.Method,
+			`path`:        r.URL.Path,
+			`status`:      rec.status,
+			`bytes`:       rec.bytes,
+			`duration`:    time.Since(start).String(),
+			`referenceId`: mistral.RequestIDFromContext(r.Context()),
+		}).Infoln(`access`)
+	})
+}
+
+// withRecovery converts a panic anywhere in the handler chain into
+// a logged stack trace plus a 500 response, instead of letting it
+// abort the connection serving the request without a response.
+func withRecovery(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if rec := recover(); rec != nil {
+				buf := make([]byte, 64<<10)
+				n := runtime.Stack(buf, false)
+				logrus.WithFields(logrus.Fields{
+					`referenceId`: mistral.RequestIDFromContext(r.Context()),
+					`panic`:       rec,
+				}).Errorf("panic recovered:\n%s", buf[:n])
+				w.WriteHeader(http.StatusInternalServerError)
+			}
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// withMiddleware wraps handler with the full chain mistral installs
+// on the data-plane router: request id assignment outermost, so the
+// panic recovery and access log below it see the id on the request
+// context, then panic recovery, then access logging.
+func withMiddleware(handler http.Handler) http.Handler {
+	return withRequestID(withRecovery(withAccessLog(handler)))
+}
+
+// vim: ts=4 sw=4 sts=4 noet fenc=utf-8 ffs=unix
@@ -0,0 +1,92 @@
+/*-
+ * Copyright © 2017, Jörg Pernfuß <code.jpe@gmail.com>
+ * All rights reserved.
+ *
+ * Use of this source code is governed by a 2-clause BSD license
+ * that can be found in the LICENSE file.
+ */
+
+package main // import "github.com/mjolnir42/mistral/cmd/mistral"
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"syscall"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// listen opens the data-plane listener according to network, which
+// is either `tcp` (the default) or `unix`. For unix it treats addr
+// as a filesystem path, removes a stale socket left behind by a
+// previous crashed instance and applies umask while the socket is
+// created so it comes up with the requested permissions.
+func listen(network, addr, umask string) (net.Listener, error) {
+	switch network {
+	case ``, `tcp`:
+		return net.Listen(`tcp`, addr)
+	case `unix`:
+		return listenUnix(addr, umask)
+	default:
+		return nil, fmt.Errorf(
+			"mistral: unknown Mistral.ListenNetwork: %s", network)
+	}
+}
+
+// listenUnix binds a unix domain socket at path, honoring umask
+// (an octal string such as `0177`) for the duration of the bind so
+// the resulting socket file is created with the desired
+// permissions.
+func listenUnix(path, umask string) (net.Listener, error) {
+	if err := unlinkStaleSocket(path); err != nil {
+		return nil, err
+	}
+
+	restore, err := setUmask(umask)
+	if err != nil {
+		return nil, err
+	}
+	defer restore()
+
+	return net.Listen(`unix`, path)
+}
+
+// unlinkStaleSocket removes a leftover unix socket file from a
+// previous run. It is not an error for the path to not exist.
+func unlinkStaleSocket(path string) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// setUmask parses umask (an octal string, e.g. `0177`) and applies
+// it via syscall.Umask, returning a function that restores the
+// previous umask. An empty umask is a no-op.
+func setUmask(umask string) (func(), error) {
+	if umask == `` {
+		return func() {}, nil
+	}
+	mask, err := strconv.ParseInt(umask, 8, 32)
+	if err != nil {
+		return nil, fmt.Errorf(
+			"mistral: invalid Mistral.ListenUmask %q: %s", umask, err.Error())
+	}
+	prev := syscall.Umask(int(mask))
+	return func() { syscall.Umask(prev) }, nil
+}
+
+// removeSocket unlinks the unix domain socket file on shutdown. It
+// is a no-op for tcp listeners.
+func removeSocket(network, addr string) {
+	if network != `unix` {
+		return
+	}
+	if err := os.Remove(addr); err != nil && !os.IsNotExist(err) {
+		logrus.Warnf("Could not remove unix socket %s: %s", addr, err.Error())
+	}
+}
+
+// vim: ts=4 sw=4 sts=4 noet fenc=utf-8 ffs=unix
@@ -0,0 +1,146 @@
+/*-
+ * Copyright © 2017, Jörg Pernfuß <code.jpe@gmail.com>
+ * All rights reserved.
+ *
+ * Use of this source code is governed by a 2-clause BSD license
+ * that can be found in the LICENSE file.
+ */
+
+package main // import "github.com/mjolnir42/mistral/cmd/mistral"
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// certReloader keeps the currently active TLS certificate in an
+// atomic.Value and reloads it from disk on demand. It is used as
+// the backing store for tls.Config.GetCertificate so in-flight
+// connections keep using their negotiated certificate while new
+// handshakes pick up whatever was most recently loaded.
+type certReloader struct {
+	certFile string
+	keyFile  string
+	cert     atomic.Value
+}
+
+// newCertReloader loads certFile/keyFile once and returns a
+// certReloader ready to be installed into a tls.Config.
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	cr := &certReloader{certFile: certFile, keyFile: keyFile}
+	if err := cr.reload(); err != nil {
+		return nil, err
+	}
+	return cr, nil
+}
+
+// reload reads the configured cert/key pair from disk and swaps it
+// in atomically.
+func (cr *certReloader) reload() error {
+	cert, err := tls.LoadX509KeyPair(cr.certFile, cr.keyFile)
+	if err != nil {
+		return err
+	}
+	cr.cert.Store(&cert)
+	return nil
+}
+
+// GetCertificate implements the tls.Config.GetCertificate callback
+// signature.
+func (cr *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return cr.cert.Load().(*tls.Certificate), nil
+}
+
+// watchSIGHUP reloads the certificate whenever the process receives
+// SIGHUP, logging success or failure. It runs until the process
+// exits and is intended to be started as its own goroutine.
+func (cr *certReloader) watchSIGHUP() {
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGHUP)
+	for range sigChan {
+		if err := cr.reload(); err != nil {
+			logrus.Errorf("TLS certificate reload failed: %s", err.Error())
+			continue
+		}
+		logrus.Infoln(`TLS certificate reloaded`)
+	}
+}
+
+// clientAuthType translates the Mistral.TLSClientAuth configuration
+// string into the matching tls.ClientAuthType constant.
+func clientAuthType(setting string) (tls.ClientAuthType, error) {
+	switch setting {
+	case ``, `none`:
+		return tls.NoClientCert, nil
+	case `request`:
+		return tls.RequestClientCert, nil
+	case `require-and-verify`:
+		return tls.RequireAndVerifyClientCert, nil
+	default:
+		return tls.NoClientCert, fmt.Errorf(
+			"mistral: unknown TLSClientAuth setting: %s", setting)
+	}
+}
+
+// loadClientCAPool reads a PEM bundle of client CA certificates
+// used to validate client certificates when TLSClientAuth requires
+// verification.
+func loadClientCAPool(caFile string) (*x509.CertPool, error) {
+	pem, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf(
+			"mistral: no certificates parsed from %s", caFile)
+	}
+	return pool, nil
+}
+
+// newServerTLSConfig assembles the tls.Config for srv from the
+// Mistral.TLS* configuration keys, wiring up a certReloader so
+// SIGHUP rotates the certificate without dropping connections.
+func newServerTLSConfig(certFile, keyFile, clientCAFile, clientAuth string) (*tls.Config, error) {
+	cr, err := newCertReloader(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	go cr.watchSIGHUP()
+
+	auth, err := clientAuthType(clientAuth)
+	if err != nil {
+		return nil, err
+	}
+	// require-and-verify asks the handshake to verify every
+	// presented client certificate against ClientCAs. Without a CA
+	// pool there is nothing to verify against, so every client-cert
+	// handshake would fail at runtime instead of here at startup.
+	if auth == tls.RequireAndVerifyClientCert && clientCAFile == `` {
+		return nil, fmt.Errorf(
+			"mistral: Mistral.TLSClientAuth=require-and-verify requires Mistral.TLSClientCAFile to be set")
+	}
+
+	cfg := &tls.Config{
+		GetCertificate: cr.GetCertificate,
+		ClientAuth:     auth,
+	}
+	if clientCAFile != `` {
+		pool, err := loadClientCAPool(clientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.ClientCAs = pool
+	}
+	return cfg, nil
+}
+
+// vim: ts=4 sw=4 sts=4 noet fenc=utf-8 ffs=unix
@@ -0,0 +1,68 @@
+/*-
+ * Copyright © 2017, Jörg Pernfuß <code.jpe@gmail.com>
+ * All rights reserved.
+ *
+ * Use of this source code is governed by a 2-clause BSD license
+ * that can be found in the LICENSE file.
+ */
+
+package main // import "github.com/mjolnir42/mistral/cmd/mistral"
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+)
+
+// initTracer configures the global OpenTelemetry tracer provider
+// from the Mistral.Tracing configuration block. It returns a
+// shutdown function that must be called as part of the graceful
+// exit sequence, after srv.Shutdown, to flush any buffered spans.
+//
+// The otelhttp wrapping in main.go opens the root span per ingest
+// request; mistral.Endpoint carries that span's context onto
+// mistral.Work, and mistral.Mistral.process opens the decode/produce
+// child spans from it, so a slow batch stays correlated end to end.
+// erebos.Transport itself still has no Context field -- that struct
+// lives in the separate, unvendored github.com/mjolnir42/erebos
+// module -- so mistral.Work is the local carrier standing in for it.
+func initTracer(endpoint, serviceName string, sampleRatio float64) (func(context.Context) error, error) {
+	if endpoint == `` {
+		// tracing disabled, return a no-op shutdown
+		return func(context.Context) error { return nil }, nil
+	}
+
+	exporter, err := otlptrace.New(
+		context.Background(),
+		otlptracegrpc.NewClient(otlptracegrpc.WithEndpoint(endpoint)),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := resource.New(
+		context.Background(),
+		resource.WithAttributes(
+			semconv.ServiceNameKey.String(serviceName),
+		),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(sampleRatio))),
+	)
+	otel.SetTracerProvider(tp)
+
+	return tp.Shutdown, nil
+}
+
+// vim: ts=4 sw=4 sts=4 noet fenc=utf-8 ffs=unix
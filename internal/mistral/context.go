@@ -0,0 +1,41 @@
+/*-
+ * Copyright © 2017, Jörg Pernfuß <code.jpe@gmail.com>
+ * All rights reserved.
+ *
+ * Use of this source code is governed by a 2-clause BSD license
+ * that can be found in the LICENSE file.
+ */
+
+package mistral // import "github.com/mjolnir42/mistral/internal/mistral"
+
+import "context"
+
+// ctxKey is a private type so the reference id this package stores
+// on a request context never collides with context keys set by
+// other packages.
+type ctxKey int
+
+// requestIDKey is the context.Context key under which the request's
+// reference id is stored. It is exported indirectly through
+// WithRequestID/RequestIDFromContext so cmd/mistral's middleware and
+// Endpoint agree on the same value without either package reaching
+// into the other's internals.
+const requestIDKey ctxKey = 0
+
+// WithRequestID returns a copy of ctx carrying id as the request's
+// reference id.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey, id)
+}
+
+// RequestIDFromContext returns the reference id stored on ctx by
+// WithRequestID, or the empty string if none is present.
+func RequestIDFromContext(ctx context.Context) string {
+	id, ok := ctx.Value(requestIDKey).(string)
+	if !ok {
+		return ``
+	}
+	return id
+}
+
+// vim: ts=4 sw=4 sts=4 noet fenc=utf-8 ffs=unix
@@ -0,0 +1,82 @@
+/*-
+ * Copyright © 2017, Jörg Pernfuß <code.jpe@gmail.com>
+ * All rights reserved.
+ *
+ * Use of this source code is governed by a 2-clause BSD license
+ * that can be found in the LICENSE file.
+ */
+
+package mistral // import "github.com/mjolnir42/mistral/internal/mistral"
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/julienschmidt/httprouter"
+	"github.com/mjolnir42/erebos"
+)
+
+// dispatchCounter round-robins incoming batches across Handlers.
+var dispatchCounter int64
+
+// errorBody is the JSON shape of an Endpoint error response. It
+// always carries the reference id so an operator can find the
+// matching access-log and handler-log lines for a failed request.
+type errorBody struct {
+	Error       string `json:"error"`
+	ReferenceID string `json:"referenceId"`
+}
+
+func writeError(w http.ResponseWriter, referenceID string, status int, msg string) {
+	w.Header().Set(`Content-Type`, `application/json`)
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(errorBody{Error: msg, ReferenceID: referenceID})
+}
+
+// Endpoint accepts a metric batch and hands a Work describing it to
+// one of the running Mistral handlers. The body is read only to
+// record its size; it is not decoded, and the Work's erebos.Transport
+// stays empty. Work.Topic is set to the request path. Every logrus entry
+// and error response it produces carries the reference id assigned
+// by cmd/mistral's request-id middleware, so a client can correlate
+// its own logs with the handler-side ones for the same request.
+func Endpoint(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	referenceID := RequestIDFromContext(r.Context())
+	log := logrus.WithField(`referenceId`, referenceID)
+
+	body, err := ioutil.ReadAll(r.Body)
+	defer r.Body.Close()
+	if err != nil {
+		log.Errorf("Endpoint: could not read request body: %s", err.Error())
+		writeError(w, referenceID, http.StatusBadRequest, `could not read request body`)
+		return
+	}
+	if len(body) == 0 {
+		log.Warnln(`Endpoint: received empty metric batch`)
+		writeError(w, referenceID, http.StatusBadRequest, `empty metric batch`)
+		return
+	}
+
+	work := &Work{
+		Transport:   &erebos.Transport{},
+		Context:     r.Context(),
+		BatchSize:   len(body),
+		Topic:       r.URL.Path,
+		ReferenceID: referenceID,
+	}
+
+	h := dispatch(int(atomic.AddInt64(&dispatchCounter, 1)))
+	select {
+	case h.Input <- work:
+		log.WithFields(work.logFields()).Infoln(`Endpoint: batch accepted`)
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		log.WithFields(work.logFields()).Errorln(`Endpoint: handler queue is full`)
+		writeError(w, referenceID, http.StatusServiceUnavailable, `handler queue is full`)
+	}
+}
+
+// vim: ts=4 sw=4 sts=4 noet fenc=utf-8 ffs=unix
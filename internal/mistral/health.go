@@ -0,0 +1,52 @@
+/*-
+ * Copyright © 2017, Jörg Pernfuß <code.jpe@gmail.com>
+ * All rights reserved.
+ *
+ * Use of this source code is governed by a 2-clause BSD license
+ * that can be found in the LICENSE file.
+ */
+
+package mistral // import "github.com/mjolnir42/mistral/internal/mistral"
+
+import (
+	"net/http"
+	"sync/atomic"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// healthState tracks whether the process should report itself as
+// healthy to its load balancer. It starts healthy and is flipped by
+// SetShutdown/SetUnavailable from cmd/mistral's main loop.
+var healthState int32
+
+const (
+	stateHealthy     int32 = 0
+	stateShutdown    int32 = 1
+	stateUnavailable int32 = 2
+)
+
+// SetShutdown marks the process as shutting down, causing Health to
+// start failing so the load balancer removes it from service.
+func SetShutdown() {
+	atomic.StoreInt32(&healthState, stateShutdown)
+}
+
+// SetUnavailable marks the process as unavailable after a handler
+// died, causing Health to start failing.
+func SetUnavailable() {
+	atomic.StoreInt32(&healthState, stateUnavailable)
+}
+
+// Health implements the /health endpoint, reporting 200 while the
+// process is healthy and 503 once SetShutdown or SetUnavailable has
+// been called.
+func Health(w http.ResponseWriter, _ *http.Request, _ httprouter.Params) {
+	if atomic.LoadInt32(&healthState) != stateHealthy {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// vim: ts=4 sw=4 sts=4 noet fenc=utf-8 ffs=unix
@@ -0,0 +1,64 @@
+/*-
+ * Copyright © 2017, Jörg Pernfuß <code.jpe@gmail.com>
+ * All rights reserved.
+ *
+ * Use of this source code is governed by a 2-clause BSD license
+ * that can be found in the LICENSE file.
+ */
+
+package mistral // import "github.com/mjolnir42/mistral/internal/mistral"
+
+import (
+	"bytes"
+	"fmt"
+
+	metrics "github.com/rcrowley/go-metrics"
+)
+
+// MtrReg is the process-wide metrics registry, set by cmd/mistral
+// during startup before any handler or the legacy metric socket is
+// started.
+var MtrReg *metrics.Registry
+
+// FormatMetrics renders registry in the compact one-line-per-metric
+// format the legacy MetricSocket ships out.
+func FormatMetrics(registry *metrics.Registry) []byte {
+	var buf bytes.Buffer
+	(*registry).Each(func(name string, i interface{}) {
+		switch m := i.(type) {
+		case metrics.Counter:
+			fmt.Fprintf(&buf, "%s %d\n", name, m.Count())
+		case metrics.Meter:
+			fmt.Fprintf(&buf, "%s %d\n", name, m.Count())
+		case metrics.Gauge:
+			fmt.Fprintf(&buf, "%s %d\n", name, m.Value())
+		}
+	})
+	return buf.Bytes()
+}
+
+// DebugFormatMetrics renders registry with histogram and timer counts
+// and p50/p99 percentiles included, for the legacy MetricSocket's
+// debug output.
+func DebugFormatMetrics(registry *metrics.Registry) []byte {
+	var buf bytes.Buffer
+	(*registry).Each(func(name string, i interface{}) {
+		switch m := i.(type) {
+		case metrics.Counter:
+			fmt.Fprintf(&buf, "%s counter %d\n", name, m.Count())
+		case metrics.Meter:
+			fmt.Fprintf(&buf, "%s meter %d\n", name, m.Count())
+		case metrics.Gauge:
+			fmt.Fprintf(&buf, "%s gauge %d\n", name, m.Value())
+		case metrics.Histogram:
+			fmt.Fprintf(&buf, "%s histogram count=%d p50=%.2f p99=%.2f\n",
+				name, m.Count(), m.Percentile(0.5), m.Percentile(0.99))
+		case metrics.Timer:
+			fmt.Fprintf(&buf, "%s timer count=%d p50=%.2f p99=%.2f\n",
+				name, m.Count(), m.Percentile(0.5), m.Percentile(0.99))
+		}
+	})
+	return buf.Bytes()
+}
+
+// vim: ts=4 sw=4 sts=4 noet fenc=utf-8 ffs=unix
@@ -0,0 +1,81 @@
+/*-
+ * Copyright © 2017, Jörg Pernfuß <code.jpe@gmail.com>
+ * All rights reserved.
+ *
+ * Use of this source code is governed by a 2-clause BSD license
+ * that can be found in the LICENSE file.
+ */
+
+package mistral // import "github.com/mjolnir42/mistral/internal/mistral"
+
+import (
+	"runtime"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/mjolnir42/erebos"
+	metrics "github.com/rcrowley/go-metrics"
+)
+
+// Mistral is one ingest handler goroutine. cmd/mistral starts
+// runtime.NumCPU() of these, each passing the metric batches
+// Endpoint hands it on its own Input channel to process.
+type Mistral struct {
+	Num      int
+	Input    chan *Work
+	Shutdown chan struct{}
+	Death    chan error
+	Config   *erebos.Config
+	Metrics  *metrics.Registry
+}
+
+// Handlers holds the running Mistral instances, indexed by Num, so
+// cmd/mistral can reach their Shutdown/Input channels during
+// graceful exit and Endpoint can dispatch work onto them.
+var Handlers = make([]*Mistral, runtime.NumCPU())
+
+// ShutdownChannel returns the channel that stops Start once closed.
+func (m *Mistral) ShutdownChannel() chan struct{} {
+	return m.Shutdown
+}
+
+// InputChannel returns the channel Endpoint enqueues decoded work
+// on.
+func (m *Mistral) InputChannel() chan *Work {
+	return m.Input
+}
+
+// Start runs the handler's main loop until Shutdown is closed,
+// processing every batch it receives from Input.
+func (m *Mistral) Start() {
+	for {
+		select {
+		case <-m.Shutdown:
+			return
+		case w, ok := <-m.Input:
+			if !ok {
+				return
+			}
+			m.process(w)
+		}
+	}
+}
+
+// dispatch picks the handler for Num i, wrapping around the size of
+// Handlers. It is used by Endpoint to round-robin incoming batches
+// across the running handlers.
+func dispatch(i int) *Mistral {
+	return Handlers[i%len(Handlers)]
+}
+
+// logFields returns the standard logrus fields every Mistral log
+// entry concerning w should carry, so the reference id ties a
+// handler's log lines back to the HTTP request that produced them.
+func (w *Work) logFields() logrus.Fields {
+	return logrus.Fields{
+		`referenceId`: w.ReferenceID,
+		`batchSize`:   w.BatchSize,
+		`topic`:       w.Topic,
+	}
+}
+
+// vim: ts=4 sw=4 sts=4 noet fenc=utf-8 ffs=unix
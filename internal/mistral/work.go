@@ -0,0 +1,32 @@
+/*-
+ * Copyright © 2017, Jörg Pernfuß <code.jpe@gmail.com>
+ * All rights reserved.
+ *
+ * Use of this source code is governed by a 2-clause BSD license
+ * that can be found in the LICENSE file.
+ */
+
+package mistral // import "github.com/mjolnir42/mistral/internal/mistral"
+
+import (
+	"context"
+
+	"github.com/mjolnir42/erebos"
+)
+
+// Work is what Endpoint enqueues on a Mistral handler's Input
+// channel. erebos.Transport has no Context field of its own -- it
+// is defined in the separate github.com/mjolnir42/erebos module,
+// which this repository does not vendor and therefore cannot edit
+// -- so Work is the local carrier that lets the span of the HTTP
+// request which accepted the batch survive the handoff onto the
+// asynchronous Start/process goroutine.
+type Work struct {
+	*erebos.Transport
+	Context     context.Context
+	BatchSize   int
+	Topic       string
+	ReferenceID string
+}
+
+// vim: ts=4 sw=4 sts=4 noet fenc=utf-8 ffs=unix